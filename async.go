@@ -0,0 +1,185 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DropPolicy controls what EnableAsync does when its buffer is full.
+type DropPolicy int
+
+const (
+	// Block makes the logging call wait until space is available. This guarantees no
+	// record is lost, at the cost of potentially stalling the caller.
+	Block DropPolicy = iota
+	// DropOldest discards the oldest queued record to make room for the new one.
+	DropOldest
+	// DropNewest discards the incoming record, leaving the queue untouched.
+	DropNewest
+)
+
+// dropReportInterval is how often the async drain loop reports how many records it
+// has had to drop, if any.
+const dropReportInterval = 30 * time.Second
+
+// asyncState holds everything EnableAsync sets up, behind a pointer so that a Logger
+// derived via With shares it with the original, instead of each tracking its own
+// drop counter and waiting on its own (never-started) goroutine.
+//
+// stopMu guards stopped against a concurrent stopAsync: every send on ch takes a read
+// lock, and stopAsync takes the write lock before flipping stopped and closing ch, so
+// no goroutine ever sends on an already-closed channel. A Logger derived via With (and
+// so sharing this asyncState after the original Logger's Close has run) sees stopped
+// and falls back to writing synchronously instead.
+type asyncState struct {
+	ch         chan logRecord
+	wg         sync.WaitGroup
+	dropPolicy DropPolicy
+	dropped    int64 // Count of records dropped since the last periodic report. Accessed atomically.
+	stopMu     sync.RWMutex
+	stopped    bool
+}
+
+// EnableAsync switches the Logger to non-blocking mode: Log and its variants enqueue
+// records onto a channel of size bufSize, which a background goroutine drains and
+// writes to the sinks. This keeps a slow disk or network sink from stalling request
+// handlers. onFull decides what happens when the buffer is full; see DropPolicy.
+//
+// Call Close or Flush to drain the buffer before the process exits. A Logger derived
+// via With shares the same async state, so draining or flushing either one applies
+// to both.
+func (l *Logger) EnableAsync(bufSize int, onFull DropPolicy) {
+	if l.async != nil {
+		return
+	}
+	a := &asyncState{
+		ch:         make(chan logRecord, bufSize),
+		dropPolicy: onFull,
+	}
+	a.wg.Add(1)
+	l.async = a
+	go l.asyncLoop(a)
+}
+
+// asyncLoop drains a.ch, writing each record to the sinks, until it is closed and
+// empty. It is passed a explicitly (rather than reading l.async) so that it keeps
+// draining the state it was started with even if EnableAsync were ever called
+// again.
+func (l *Logger) asyncLoop(a *asyncState) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(dropReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case rec, ok := <-a.ch:
+			if !ok {
+				return
+			}
+			if rec.flush != nil {
+				close(rec.flush)
+				continue
+			}
+			l.writeRecord(rec)
+		case <-ticker.C:
+			l.reportDropped(a)
+		}
+	}
+}
+
+// reportDropped logs, and resets, the count of records dropped since the last report.
+func (l *Logger) reportDropped(a *asyncState) {
+	n := atomic.SwapInt64(&a.dropped, 0)
+	if n > 0 {
+		l.writeRecord(logRecord{level: Warn, msg: fmt.Sprintf("log: dropped %v records; async buffer was full", n), ts: time.Now()})
+	}
+}
+
+// enqueueAsync queues rec for the background drain loop, applying the configured
+// DropPolicy if the buffer is full. It returns false if async mode isn't enabled, or
+// its async state has been stopped (e.g. by Close on a Logger this one shares it
+// with), in which case the caller should write rec itself.
+func (l *Logger) enqueueAsync(rec logRecord) bool {
+	a := l.async
+	if a == nil {
+		return false
+	}
+	a.stopMu.RLock()
+	defer a.stopMu.RUnlock()
+	if a.stopped {
+		return false
+	}
+	switch a.dropPolicy {
+	case Block:
+		a.ch <- rec
+	case DropNewest:
+		select {
+		case a.ch <- rec:
+		default:
+			atomic.AddInt64(&a.dropped, 1)
+		}
+	case DropOldest:
+		select {
+		case a.ch <- rec:
+		default:
+			select {
+			case <-a.ch:
+				atomic.AddInt64(&a.dropped, 1)
+			default:
+			}
+			select {
+			case a.ch <- rec:
+			default:
+				atomic.AddInt64(&a.dropped, 1)
+			}
+		}
+	}
+	return true
+}
+
+// Flush blocks until every record enqueued so far (by this goroutine's happens-before
+// order) has been written to the sinks, or ctx is cancelled. It is a no-op if
+// EnableAsync hasn't been called, or its async state has already been stopped.
+func (l *Logger) Flush(ctx context.Context) error {
+	a := l.async
+	if a == nil {
+		return nil
+	}
+	a.stopMu.RLock()
+	if a.stopped {
+		a.stopMu.RUnlock()
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case a.ch <- logRecord{flush: done}:
+		a.stopMu.RUnlock()
+	case <-ctx.Done():
+		a.stopMu.RUnlock()
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopAsync drains and stops the async goroutine, if EnableAsync was called. Close calls this.
+func (l *Logger) stopAsync() {
+	a := l.async
+	if a == nil {
+		return
+	}
+	a.stopMu.Lock()
+	if !a.stopped {
+		a.stopped = true
+		close(a.ch)
+	}
+	a.stopMu.Unlock()
+	a.wg.Wait()
+	l.async = nil
+}
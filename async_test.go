@@ -0,0 +1,131 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEnableAsyncDropPolicies(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy DropPolicy
+	}{
+		{"Block", Block},
+		{"DropOldest", DropOldest},
+		{"DropNewest", DropNewest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l := newTestLogger()
+			var buf bytes.Buffer
+			l.AddSink(&Sink{Name: "buf", Writer: &buf})
+			l.EnableAsync(4, c.policy)
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					l.Info("hello")
+				}
+			}()
+			wg.Wait()
+
+			if err := l.Flush(context.Background()); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			l.Close()
+		})
+	}
+}
+
+func TestWithSharesAsyncDropCounter(t *testing.T) {
+	l := newTestLogger()
+	l.AddSink(&Sink{Name: "buf", Writer: &bytes.Buffer{}})
+	l.EnableAsync(1, DropNewest)
+
+	child := l.With("requestId", "abc")
+	for i := 0; i < 1000; i++ {
+		child.Info("hello")
+	}
+
+	if l.async != child.async {
+		t.Fatal("expected child logger to share the parent's async state")
+	}
+	if dropped := l.async.dropped; dropped == 0 {
+		t.Fatal("expected some records to have been dropped")
+	}
+
+	l.Close()
+}
+
+func TestChildSurvivesParentCloseWithoutPanicking(t *testing.T) {
+	l := newTestLogger()
+	var buf bytes.Buffer
+	l.AddSink(&Sink{Name: "buf", Writer: &buf})
+	l.EnableAsync(4, Block)
+
+	child := l.With("requestId", "abc")
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Used to panic with "send on closed channel": child still shares the parent's
+	// (now-stopped) asyncState, so it must fall back to a synchronous write instead
+	// of enqueueing.
+	child.Info("still alive")
+	if !bytes.Contains(buf.Bytes(), []byte("still alive")) {
+		t.Fatalf("expected the post-Close record to be written synchronously, got %q", buf.String())
+	}
+}
+
+type slowWriter struct {
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return len(p), nil
+}
+
+func TestWriteGoesThroughAsyncPipeline(t *testing.T) {
+	l := newTestLogger()
+	l.AddSink(&Sink{Name: "slow", Writer: &slowWriter{delay: 200 * time.Millisecond}})
+	l.EnableAsync(100, Block)
+
+	start := time.Now()
+	if _, err := l.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected Write to return immediately via the async pipeline, took %v", elapsed)
+	}
+
+	if err := l.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	l.Close()
+}
+
+func TestFlushWaitsForEnqueuedRecords(t *testing.T) {
+	l := newTestLogger()
+	var buf bytes.Buffer
+	l.AddSink(&Sink{Name: "buf", Writer: &buf})
+	l.EnableAsync(100, Block)
+
+	for i := 0; i < 10; i++ {
+		l.Info("hello")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if bytes.Count(buf.Bytes(), []byte("hello")) != 10 {
+		t.Fatalf("expected Flush to wait for all 10 records, got %q", buf.String())
+	}
+	l.Close()
+}
@@ -0,0 +1,194 @@
+package log
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Config describes a Logger, as loaded by LoadConfig. It is encoded as either JSON
+// or XML, chosen by the file extension of the path passed to LoadConfig.
+type Config struct {
+	Level         string            `json:"level,omitempty" xml:"Level,omitempty"`
+	Sinks         []SinkConfig      `json:"sinks,omitempty" xml:"Sinks>Sink,omitempty"`
+	PackageLevels map[string]string `json:"packageLevels,omitempty" xml:"-"`
+}
+
+// SinkConfig describes a single Sink, as used inside Config.
+type SinkConfig struct {
+	// Type selects the kind of sink: "file", "stdout", "stderr", "socket", or "syslog".
+	Type   string `json:"type" xml:"Type"`
+	Level  string `json:"level,omitempty" xml:"Level,omitempty"`
+	Format string `json:"format,omitempty" xml:"Format,omitempty"` // "text" (default) or "json"
+
+	// Filename, MaxSize (MB) and MaxBackups apply to Type "file".
+	Filename   string `json:"filename,omitempty" xml:"Filename,omitempty"`
+	MaxSize    int    `json:"maxSize,omitempty" xml:"MaxSize,omitempty"`
+	MaxBackups int    `json:"maxBackups,omitempty" xml:"MaxBackups,omitempty"`
+
+	// Address and Protocol apply to Type "socket" and "syslog". Protocol is "tcp" or
+	// "udp", defaulting to "tcp".
+	Address  string `json:"address,omitempty" xml:"Address,omitempty"`
+	Protocol string `json:"protocol,omitempty" xml:"Protocol,omitempty"`
+
+	// AppName and Facility apply to Type "syslog". Facility is one of the names
+	// accepted by parseSyslogFacility, e.g. "local0". AppName defaults to the base name
+	// of os.Args[0].
+	AppName  string `json:"appName,omitempty" xml:"AppName,omitempty"`
+	Facility string `json:"facility,omitempty" xml:"Facility,omitempty"`
+}
+
+// LoadConfig reads a Logger configuration from path and builds the Logger it
+// describes. The file is parsed as XML if path ends in ".xml", and as JSON otherwise.
+//
+// PackageLevels lets operators raise or lower the level of one noisy subsystem
+// without recompiling: it is checked against the import path of the function that
+// made the logging call, and if a match is found, it overrides Logger.Level for that
+// call only. JSON config encodes it as a plain object of import-path to level, e.g.
+// {"packageLevels": {"github.com/IMQS/module/ingest": "debug"}}. XML config has no
+// PackageLevels; it is ignored when parsing XML.
+func LoadConfig(path string) (*Logger, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".xml") {
+		err = xml.Unmarshal(data, &cfg)
+	} else {
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("log: parsing config %v: %w", path, err)
+	}
+
+	l := &Logger{Level: Info, sinksMu: &sync.RWMutex{}}
+	if cfg.Level != "" {
+		if l.Level, err = ParseLevel(cfg.Level); err != nil {
+			return nil, fmt.Errorf("log: parsing config %v: %w", path, err)
+		}
+	}
+
+	for i, sc := range cfg.Sinks {
+		sink, err := buildSinkFromConfig(sc)
+		if err != nil {
+			return nil, fmt.Errorf("log: building sink %v of %v: %w", i, path, err)
+		}
+		l.AddSink(sink)
+	}
+
+	if len(cfg.PackageLevels) != 0 {
+		l.packageLevels = make(map[string]Level, len(cfg.PackageLevels))
+		for pkg, levStr := range cfg.PackageLevels {
+			lev, err := ParseLevel(levStr)
+			if err != nil {
+				return nil, fmt.Errorf("log: parsing config %v: package %v: %w", path, pkg, err)
+			}
+			l.packageLevels[pkg] = lev
+		}
+	}
+
+	return l, nil
+}
+
+// buildSinkFromConfig constructs a Sink from its configuration.
+func buildSinkFromConfig(sc SinkConfig) (*Sink, error) {
+	sink := &Sink{Name: sc.Filename}
+	if sc.Level != "" {
+		lev, err := ParseLevel(sc.Level)
+		if err != nil {
+			return nil, err
+		}
+		sink.Level = lev
+	}
+	if strings.EqualFold(sc.Format, "json") {
+		sink.Format = FormatJSON
+	}
+
+	protocol := sc.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	switch strings.ToLower(sc.Type) {
+	case "file":
+		sink.Writer = &lumberjack.Logger{
+			Filename:   sc.Filename,
+			MaxSize:    orDefaultInt(sc.MaxSize, 30),
+			MaxBackups: orDefaultInt(sc.MaxBackups, 3),
+		}
+	case "stdout":
+		sink.Name = "stdout"
+		sink.Writer = os.Stdout
+	case "stderr":
+		sink.Name = "stderr"
+		sink.Writer = os.Stderr
+	case "socket":
+		sink.Name = fmt.Sprintf("%v:%v", protocol, sc.Address)
+		sink.Writer = newSocketSink(protocol, sc.Address)
+	case "syslog":
+		facility, err := parseSyslogFacility(sc.Facility)
+		if err != nil {
+			return nil, err
+		}
+		appName := sc.AppName
+		if appName == "" {
+			appName = filepath.Base(os.Args[0])
+		}
+		sink.Name = fmt.Sprintf("syslog:%v", sc.Address)
+		sink.Writer = newSyslogSink(protocol, sc.Address, facility, appName)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+
+	return sink, nil
+}
+
+func orDefaultInt(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+// packageLevelOverride resolves the import path of the function that called into
+// this Logger (see logw for the stack depth this assumes), and returns the level
+// configured for it via LoadConfig's PackageLevels, if any.
+func (l *Logger) packageLevelOverride() (Level, bool) {
+	if len(l.packageLevels) == 0 {
+		return 0, false
+	}
+	pc, _, _, ok := runtime.Caller(3 + l.callerSkip)
+	if !ok {
+		return 0, false
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return 0, false
+	}
+	lev, ok := l.packageLevels[packageFromFuncName(fn.Name())]
+	return lev, ok
+}
+
+// packageFromFuncName extracts the import path from a runtime function name, e.g.
+// "github.com/IMQS/module/ingest.(*Worker).Run" becomes "github.com/IMQS/module/ingest".
+func packageFromFuncName(name string) string {
+	prefix := ""
+	rest := name
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		prefix = name[:i+1]
+		rest = name[i+1:]
+	}
+	if i := strings.IndexByte(rest, '.'); i >= 0 {
+		rest = rest[:i]
+	}
+	return prefix + rest
+}
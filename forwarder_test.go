@@ -0,0 +1,30 @@
+package log
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestForwarderWriteDoesNotCorruptTargetCallerSkip(t *testing.T) {
+	l := newTestLogger()
+	l.IncludeCaller = true
+	var buf bytes.Buffer
+	l.AddSink(&Sink{Name: "buf", Writer: &buf})
+
+	fwd := NewForwarder(0, Info, l)
+	fwd.Write([]byte("forwarded\n")) // this file's name is asserted against below
+
+	if !strings.Contains(buf.String(), "forwarder_test.go") {
+		t.Fatalf("expected the caller reported for a forwarded message to be this test file, got %q", buf.String())
+	}
+
+	buf.Reset()
+	l.Info("direct") // this file's name is asserted against below
+	if !strings.Contains(buf.String(), "forwarder_test.go") {
+		t.Fatalf("expected a direct call through the same Logger to still resolve its own caller correctly, got %q", buf.String())
+	}
+	if l.callerSkip != 0 {
+		t.Fatalf("expected Forwarder to leave Target.callerSkip untouched, got %v", l.callerSkip)
+	}
+}
@@ -12,13 +12,59 @@ You can write to it using the various logging methods.
 
 If you need to forward other log messages to this system, then Forwarder might have
 sufficient functionality to achieve that.
+
+# Structured logging
+
+The *w methods (Infow, Errorw, etc) and With accept key/value pairs that are attached
+to each record. By default these are rendered as "key=value" suffixes on the usual
+text line; call SetFormat(FormatJSON) to emit one JSON object per line instead.
+
+# Sinks
+
+A Logger fans every record out to one or more Sinks, each with its own Level and
+Format. New installs a default file (or stdout/stderr) sink, plus a second stdout
+sink when running in Docker or when logToStdout is set, unless the first sink is
+already stdout. Use AddSink and RemoveSink
+to add further destinations, such as a socket or syslog sink.
+
+# Caller annotation
+
+Set Logger.IncludeCaller to true to have every record prefixed with the source
+file:line of the logging call. External code that wraps a Logger behind its own
+function should call SetCallerSkip on its own Logger to compensate for that extra
+stack frame.
+
+# External configuration
+
+LoadConfig builds a Logger from a JSON or XML file describing its level and sinks,
+plus (in JSON only) per-package level overrides, so operators can reconfigure a
+running service without a recompile. See Config for the file format.
+
+# Async logging
+
+Call EnableAsync to have logging calls enqueue records for a background goroutine to
+write, instead of writing them inline, so a slow sink can't stall a caller. Use Flush
+to wait for the queue to drain, and Close to drain it and stop the goroutine.
+
+# Network sinks
+
+A socket sink (Type "socket" in LoadConfig) ships newline-delimited records to a TCP
+or UDP collector, and a syslog sink (Type "syslog") frames them as RFC 5424 messages.
+Both reconnect automatically on write failure, buffering a small amount of unsent
+data while the remote side is unreachable. Reconnect attempts are made inside Write,
+at most once per backoff window, and such a Write blocks for up to the dial timeout.
 */
 package log
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -35,6 +81,18 @@ const (
 	Error
 )
 
+// Format controls how a Logger renders its records.
+type Format int
+
+const (
+	// FormatText renders "time [level] message key=value key=value", matching
+	// the original behaviour of this package.
+	FormatText Format = iota
+	// FormatJSON renders a single JSON object per line, containing ts, level,
+	// msg, and any persistent or call-site fields.
+	FormatJSON
+)
+
 const (
 	Stdout  = "stdout"
 	Stderr  = "stderr"
@@ -65,14 +123,115 @@ func levelToName(level Level) string {
 	panic("Unknown log level")
 }
 
+// A Sink is one destination that a Logger writes records to. A Logger may have
+// any number of sinks, each with its own minimum level and format.
+type Sink struct {
+	Name   string // Optional label, used in diagnostic messages (e.g. "app.log", "stdout", "collector")
+	Level  Level  // Messages below this level are not written to this sink. Default is Trace (everything)
+	Format Format
+	Writer io.Writer
+
+	shownError bool
+}
+
 // A logger object. Use New() to construct one.
 type Logger struct {
-	Level      Level // Log messages with a level lower than this are discarded. Default level is Info
-	testing    *testing.T
-	filename   string
-	log        io.Writer
-	shownError bool
-	inDocker   bool
+	Level         Level // Log messages with a level lower than this are discarded. Default level is Info
+	IncludeCaller bool  // If true, records are annotated with the source file:line of the logging call
+	testing       *testing.T
+	filename      string
+	sinksMu       *sync.RWMutex
+	sinks         []*Sink
+	inDocker      bool
+	fields        map[string]interface{} // Persistent fields, attached to every record emitted by this Logger. Set via With().
+	callerSkip    int                    // Extra stack frames to skip when resolving the caller, for external wrapper functions
+	packageLevels map[string]Level       // Per-package minimum level overrides, keyed by import path. Set via LoadConfig.
+
+	async *asyncState // Non-nil once EnableAsync has been called. Shared with any Logger derived via With.
+}
+
+// logRecord is a fully-prepared log record, either written immediately, or queued for
+// the async drain loop if EnableAsync is in effect.
+type logRecord struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+	caller string
+	ts     time.Time
+	raw    []byte        // If set (by Write), written verbatim instead of being formatted from msg/fields
+	flush  chan struct{} // If set, the drain loop closes this instead of writing a record
+}
+
+// SetCallerSkip tells IncludeCaller to skip n additional stack frames before recording
+// the call site. This is needed by external code that wraps a Logger behind its own
+// function call, which would otherwise have that function reported as the caller
+// instead of whoever called it. It applies to every call made through this Logger, so
+// don't call it on a Logger that's also used for direct logging elsewhere; give the
+// wrapper its own Logger (e.g. via With) instead.
+func (l *Logger) SetCallerSkip(n int) {
+	l.callerSkip = n
+}
+
+// AddSink adds a new destination that records will be fanned out to, in addition
+// to any sinks already installed. It may be called concurrently with logging calls.
+func (l *Logger) AddSink(sink *Sink) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	l.sinks = append(l.sinks, sink)
+}
+
+// RemoveSink removes a previously added sink. It is a no-op if sink is not installed.
+// It may be called concurrently with logging calls.
+func (l *Logger) RemoveSink(sink *Sink) {
+	l.sinksMu.Lock()
+	defer l.sinksMu.Unlock()
+	for i, s := range l.sinks {
+		if s == sink {
+			l.sinks = append(l.sinks[:i], l.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetFormat changes the format of every sink currently installed on this Logger.
+// The default format is FormatText. To give individual sinks different formats,
+// set Sink.Format directly instead.
+func (l *Logger) SetFormat(format Format) {
+	l.sinksMu.RLock()
+	defer l.sinksMu.RUnlock()
+	for _, s := range l.sinks {
+		s.Format = format
+	}
+}
+
+// With returns a new Logger that shares this Logger's destination and settings, but
+// carries the given key/value fields on every record it emits, in addition to any
+// fields passed at the call site. kv is a sequence of alternating keys and values,
+// e.g. With("requestId", id, "userId", userID).
+func (l *Logger) With(kv ...interface{}) *Logger {
+	child := *l
+	child.fields = mergeFields(l.fields, kv)
+	return &child
+}
+
+// mergeFields combines a base field set with a flat key/value slice, as accepted by
+// With and the *w logging methods. Keys must be strings; malformed pairs are ignored.
+func mergeFields(base map[string]interface{}, kv []interface{}) map[string]interface{} {
+	if len(base) == 0 && len(kv) == 0 {
+		return nil
+	}
+	merged := make(map[string]interface{}, len(base)+len(kv)/2)
+	for k, v := range base {
+		merged[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		merged[key] = kv[i+1]
+	}
+	return merged
 }
 
 // New creates a new logger. If logToStdout is true all logs will be written to
@@ -86,27 +245,30 @@ func New(filename string, logToStdout bool) *Logger {
 	l := &Logger{
 		Level:    Info,
 		filename: filename,
+		sinksMu:  &sync.RWMutex{},
 	}
 	if _, err := os.Stat("/.dockerenv"); !os.IsNotExist(err) {
 		l.inDocker = true
 	}
 
+	var primary io.Writer
+	name := filename
 	if filename == Stdout {
-		l.log = io.Writer(os.Stdout)
+		primary = os.Stdout
 	} else if filename == Stderr {
-		l.log = io.Writer(os.Stderr)
+		primary = os.Stderr
 	} else {
-		lj := &lumberjack.Logger{
+		primary = &lumberjack.Logger{
 			Filename:   filename,
 			MaxSize:    30,
 			MaxBackups: 3,
 		}
-		l.log = io.Writer(lj)
 	}
+	l.AddSink(&Sink{Name: name, Writer: primary})
 
 	// We always log to stdout for docker
 	if (l.inDocker || logToStdout) && filename != Stdout {
-		l.log = io.MultiWriter(os.Stdout, l.log)
+		l.AddSink(&Sink{Name: "stdout", Writer: os.Stdout})
 	}
 
 	Log = l
@@ -119,18 +281,29 @@ func NewTesting(t *testing.T) *Logger {
 	return &Logger{
 		Level:   Info,
 		testing: t,
+		sinksMu: &sync.RWMutex{},
 	}
 }
 
-// Close attempts to close the connection
+// Close attempts to close every sink's destination. It returns the first error
+// encountered. Sinks writing to os.Stdout or os.Stderr are left open, since those
+// belong to the process rather than to this Logger.
 func (l *Logger) Close() error {
-	if wc, ok := l.log.(io.WriteCloser); ok {
-		return wc.Close()
-	} else if s, ok := l.log.(*os.File); ok {
-		// Close connection to stdout/stderr
-		return s.Close()
+	l.stopAsync()
+	l.sinksMu.RLock()
+	defer l.sinksMu.RUnlock()
+	var firstErr error
+	for _, s := range l.sinks {
+		if s.Writer == os.Stdout || s.Writer == os.Stderr {
+			continue
+		}
+		if wc, ok := s.Writer.(io.WriteCloser); ok {
+			if err := wc.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
 	}
-	return nil
+	return firstErr
 }
 
 // Parse a level string such as "info" or "warn". Only the first character of the string is considered.
@@ -157,73 +330,258 @@ func ParseLevel(lev string) (Level, error) {
 }
 
 func (l *Logger) Tracef(format string, params ...interface{}) {
-	l.Logf(Trace, format, params...)
+	if Trace < l.Level && len(l.packageLevels) == 0 {
+		return
+	}
+	l.logw(Trace, fmt.Sprintf(format, params...), nil)
 }
 
 func (l *Logger) Debugf(format string, params ...interface{}) {
-	l.Logf(Debug, format, params...)
+	if Debug < l.Level && len(l.packageLevels) == 0 {
+		return
+	}
+	l.logw(Debug, fmt.Sprintf(format, params...), nil)
 }
 
 func (l *Logger) Infof(format string, params ...interface{}) {
-	l.Logf(Info, format, params...)
+	if Info < l.Level && len(l.packageLevels) == 0 {
+		return
+	}
+	l.logw(Info, fmt.Sprintf(format, params...), nil)
 }
 
 func (l *Logger) Warnf(format string, params ...interface{}) {
-	l.Logf(Warn, format, params...)
+	if Warn < l.Level && len(l.packageLevels) == 0 {
+		return
+	}
+	l.logw(Warn, fmt.Sprintf(format, params...), nil)
 }
 
 func (l *Logger) Errorf(format string, params ...interface{}) {
-	l.Logf(Error, format, params...)
+	if Error < l.Level && len(l.packageLevels) == 0 {
+		return
+	}
+	l.logw(Error, fmt.Sprintf(format, params...), nil)
+}
+
+func (l *Logger) Tracew(msg string, kv ...interface{}) {
+	l.logw(Trace, msg, kv)
+}
+
+func (l *Logger) Debugw(msg string, kv ...interface{}) {
+	l.logw(Debug, msg, kv)
+}
+
+func (l *Logger) Infow(msg string, kv ...interface{}) {
+	l.logw(Info, msg, kv)
+}
+
+func (l *Logger) Warnw(msg string, kv ...interface{}) {
+	l.logw(Warn, msg, kv)
+}
+
+func (l *Logger) Errorw(msg string, kv ...interface{}) {
+	l.logw(Error, msg, kv)
 }
 
 func (l *Logger) Trace(msg string) {
-	l.Log(Trace, msg)
+	l.logw(Trace, msg, nil)
 }
 
 func (l *Logger) Debug(msg string) {
-	l.Log(Debug, msg)
+	l.logw(Debug, msg, nil)
 }
 
 func (l *Logger) Info(msg string) {
-	l.Log(Info, msg)
+	l.logw(Info, msg, nil)
 }
 
 func (l *Logger) Warn(msg string) {
-	l.Log(Warn, msg)
+	l.logw(Warn, msg, nil)
 }
 
 func (l *Logger) Error(msg string) {
-	l.Log(Error, msg)
+	l.logw(Error, msg, nil)
 }
 
 func (l *Logger) Logf(level Level, format string, params ...interface{}) {
-	if level >= l.Level {
-		l.Log(level, fmt.Sprintf(format, params...))
+	if level < l.Level && len(l.packageLevels) == 0 {
+		return
 	}
+	l.logw(level, fmt.Sprintf(format, params...), nil)
 }
 
 func (l *Logger) Log(level Level, msg string) {
-	if level >= l.Level {
-		suffix := ""
-		if len(msg) == 0 || msg[len(msg)-1] != '\n' {
-			suffix = "\n"
+	l.logw(level, msg, nil)
+}
+
+// Logw writes a log record with structured key/value fields, merged with any
+// persistent fields set via With. kv is a sequence of alternating keys and values.
+func (l *Logger) Logw(level Level, msg string, kv ...interface{}) {
+	l.logw(level, msg, kv)
+}
+
+// logw is the single place every logging method funnels through, which is what lets
+// the frame count used by callerString and packageLevelOverride stay a constant (plus
+// any callerSkip): every exported method above calls logw directly,
+// so the call site is always exactly two frames up (logw's caller's caller).
+func (l *Logger) logw(level Level, msg string, kv []interface{}) {
+	minLevel := l.Level
+	if override, ok := l.packageLevelOverride(); ok {
+		minLevel = override
+	}
+	if level < minLevel {
+		return
+	}
+	fields := mergeFields(l.fields, kv)
+	caller := l.callerString()
+	if l.testing != nil {
+		l.testing.Logf("[%v] %v%v%v", levelToName(level)[0:1], caller, msg, formatFieldsText(fields))
+		return
+	}
+	rec := logRecord{level: level, msg: msg, fields: fields, caller: caller, ts: time.Now()}
+	if !l.enqueueAsync(rec) {
+		l.writeRecord(rec)
+	}
+}
+
+// writeRecord renders a record and fans it out to every sink whose level admits it.
+// Called either directly, or from the async drain loop when EnableAsync is in effect.
+func (l *Logger) writeRecord(rec logRecord) {
+	if rec.raw != nil {
+		l.sinksMu.RLock()
+		defer l.sinksMu.RUnlock()
+		for _, sink := range l.sinks {
+			if rec.level < sink.Level {
+				continue
+			}
+			l.writeToSink(sink, rec.level, rec.raw)
 		}
-		if l.testing != nil {
-			l.testing.Logf("[%v] %v", levelToName(level)[0:1], msg)
+		return
+	}
+	suffix := ""
+	if len(rec.msg) == 0 || rec.msg[len(rec.msg)-1] != '\n' {
+		suffix = "\n"
+	}
+	text := fmt.Sprintf("%v [%v] %v%v%v%v", rec.ts.Format(timeFormat), levelToName(rec.level)[0:1], rec.caller, rec.msg, formatFieldsText(rec.fields), suffix)
+	var jsonLine []byte
+	l.sinksMu.RLock()
+	defer l.sinksMu.RUnlock()
+	for _, sink := range l.sinks {
+		if rec.level < sink.Level {
+			continue
+		}
+		if sink.Format == FormatJSON {
+			if jsonLine == nil {
+				jsonLine = formatRecordJSON(rec.level, rec.msg, rec.fields, rec.caller, rec.ts)
+			}
+			l.writeToSink(sink, rec.level, jsonLine)
 		} else {
-			s := fmt.Sprintf("%v [%v] %v%v", time.Now().Format(timeFormat), levelToName(level)[0:1], msg, suffix)
-			l.Write([]byte(s))
+			l.writeToSink(sink, rec.level, []byte(text))
 		}
 	}
 }
 
+// callerString resolves the file:line of the original logging call, if IncludeCaller
+// is set. skip accounts for this function, logw, and the exported method that called
+// it, plus any extra frames set via SetCallerSkip for wrappers that sit above those.
+func (l *Logger) callerString() string {
+	if !l.IncludeCaller {
+		return ""
+	}
+	_, file, line, ok := runtime.Caller(3 + l.callerSkip)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%v:%v ", shortCallerFile(file), line)
+}
+
+// shortCallerFile trims a caller's absolute path down to its parent directory and
+// filename, e.g. "/go/src/imqs/foo/bar.go" becomes "foo/bar.go".
+func shortCallerFile(file string) string {
+	if i := strings.LastIndexByte(file, '/'); i >= 0 {
+		if j := strings.LastIndexByte(file[:i], '/'); j >= 0 {
+			return file[j+1:]
+		}
+	}
+	return file
+}
+
+// levelWriter is implemented by sinks that need to know the Level of each record
+// they're writing, in addition to its formatted bytes — for example, a syslog sink
+// deriving an RFC 5424 severity. Sinks that don't need this can just implement io.Writer.
+type levelWriter interface {
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+// writeToSink writes a fully formatted record to a single sink, printing (once) to
+// stdout if the sink's destination is failing.
+func (l *Logger) writeToSink(sink *Sink, level Level, line []byte) {
+	var err error
+	if lw, ok := sink.Writer.(levelWriter); ok {
+		_, err = lw.WriteLevel(level, line)
+	} else {
+		_, err = sink.Writer.Write(line)
+	}
+	if err != nil && !sink.shownError {
+		sink.shownError = true
+		name := sink.Name
+		if name == "" {
+			name = "(unnamed sink)"
+		}
+		fmt.Printf("Unable to write to log %v: %v. This error will not be shown again.\n", name, err)
+	}
+}
+
+// formatFieldsText renders fields as " key=value key=value", in a stable key order,
+// for appending to a text-format record. Returns an empty string if there are no fields.
+func formatFieldsText(fields map[string]interface{}) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := ""
+	for _, k := range keys {
+		s += fmt.Sprintf(" %v=%v", k, fields[k])
+	}
+	return s
+}
+
+// formatRecordJSON renders a single-line JSON record, merging ts, level, msg, caller
+// (if non-empty) and fields.
+func formatRecordJSON(level Level, msg string, fields map[string]interface{}, caller string, ts time.Time) []byte {
+	record := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["ts"] = ts.Format(timeFormat)
+	record["level"] = levelToName(level)
+	if caller != "" {
+		record["caller"] = strings.TrimSpace(caller)
+	}
+	record["msg"] = msg
+	line, err := json.Marshal(record)
+	if err != nil {
+		return []byte(fmt.Sprintf(`{"ts":%q,"level":"Error","msg":"failed to marshal log record: %v"}`+"\n", time.Now().Format(timeFormat), err))
+	}
+	return append(line, '\n')
+}
+
+// Write implements io.Writer, so that a Logger can be used anywhere a raw writer is
+// expected. The bytes are written verbatim (no formatting) at Info level, to every
+// sink whose Level admits Info. Like the other logging methods, this goes through the
+// async pipeline if EnableAsync is in effect, so a slow sink can't stall the caller.
 func (l *Logger) Write(p []byte) (n int, err error) {
-	n, err = l.log.Write(p)
-	if err != nil && !l.shownError {
-		l.shownError = true
-		fmt.Printf("Unable to write to log file %v: %v. This error will not be shown again.\n", l.filename, err)
+	raw := append([]byte(nil), p...)
+	rec := logRecord{level: Info, raw: raw}
+	if !l.enqueueAsync(rec) {
+		l.writeRecord(rec)
 	}
-	return
+	return len(p), nil
 }
 
 // Forwards log messages to an existing Logger, while performing some sanitizing which
@@ -243,9 +601,13 @@ func NewForwarder(stripPrefixLen int, level Level, target *Logger) *Forwarder {
 	}
 }
 
+// Write calls logw on f.Target directly, rather than going through Log, so that the
+// caller resolved for IncludeCaller is Write's caller rather than Write itself. This
+// means Forwarder needs no SetCallerSkip compensation, and so doesn't have to mutate
+// f.Target's callerSkip, which Target may be a Logger also used directly elsewhere.
 func (f *Forwarder) Write(p []byte) (n int, err error) {
 	if len(p) > f.StripPrefixLen {
-		f.Target.Log(f.Level, string(p[f.StripPrefixLen:]))
+		f.Target.logw(f.Level, string(p[f.StripPrefixLen:]), nil)
 	}
 	return len(p), nil
 }
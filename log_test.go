@@ -0,0 +1,90 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+// newTestLogger builds a Logger bypassing New's global-singleton behaviour, so that
+// each test gets its own independent instance.
+func newTestLogger() *Logger {
+	return &Logger{Level: Info, sinksMu: &sync.RWMutex{}}
+}
+
+func TestCloseDoesNotCloseStdoutStderr(t *testing.T) {
+	l := newTestLogger()
+	l.AddSink(&Sink{Name: "stdout", Writer: os.Stdout})
+	l.AddSink(&Sink{Name: "stderr", Writer: os.Stderr})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stdout.WriteString(""); err != nil {
+		t.Fatalf("stdout was closed: %v", err)
+	}
+	if _, err := os.Stderr.WriteString(""); err != nil {
+		t.Fatalf("stderr was closed: %v", err)
+	}
+}
+
+type closeCountingWriter struct {
+	bytes.Buffer
+	closed int
+}
+
+func (w *closeCountingWriter) Close() error {
+	w.closed++
+	return nil
+}
+
+func TestCloseClosesOtherSinks(t *testing.T) {
+	l := newTestLogger()
+	w := &closeCountingWriter{}
+	l.AddSink(&Sink{Name: "file", Writer: w})
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if w.closed != 1 {
+		t.Fatalf("expected sink to be closed once, got %v", w.closed)
+	}
+}
+
+func TestWriteHonoursSinkLevel(t *testing.T) {
+	l := newTestLogger()
+	var all, warnOnly bytes.Buffer
+	l.AddSink(&Sink{Name: "all", Writer: &all})
+	l.AddSink(&Sink{Name: "warnOnly", Level: Warn, Writer: &warnOnly})
+
+	if _, err := l.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if all.Len() == 0 {
+		t.Fatal("expected the unfiltered sink to receive the write")
+	}
+	if warnOnly.Len() != 0 {
+		t.Fatalf("expected the Warn-level sink to not receive an Info-level write, got %q", warnOnly.String())
+	}
+}
+
+func TestAddRemoveSinkConcurrentWithLogging(t *testing.T) {
+	l := newTestLogger()
+	l.AddSink(&Sink{Name: "base", Writer: io.Discard})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			l.Info("hello")
+		}()
+		go func() {
+			defer wg.Done()
+			s := &Sink{Name: "extra", Writer: io.Discard}
+			l.AddSink(s)
+			l.RemoveSink(s)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,234 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxSocketBufferBytes bounds how much unsent data a socketSink holds in memory while
+// its connection is down. Once exceeded, the oldest buffered records are dropped to
+// make room.
+const maxSocketBufferBytes = 64 * 1024
+
+// reconnectBackoff is the minimum time a socketSink waits between dial attempts while
+// its connection is down, so that a remote that's slow to refuse (rather than
+// instantly resetting) doesn't make every single Write block for up to its dial
+// timeout.
+const reconnectBackoff = 2 * time.Second
+
+// socketSink is an io.Writer that ships bytes to a TCP or UDP collector, reconnecting
+// automatically on write failure. Data that can't be sent is held in a small bounded
+// buffer and retried on the next write, with the oldest whole records dropped once
+// that buffer is full. Reconnecting is done synchronously inside Write: dial attempts
+// are rate-limited by reconnectBackoff, and a Write that makes one blocks for up to
+// the 5-second dial timeout.
+type socketSink struct {
+	mu              sync.Mutex
+	network         string // "tcp" or "udp"
+	address         string
+	conn            net.Conn
+	buf             bytes.Buffer
+	dropped         int64
+	lastDialAttempt time.Time
+}
+
+// newSocketSink creates a socket sink and makes a best-effort initial connection
+// attempt. A failed attempt is not an error: it's simply retried on a later Write,
+// subject to reconnectBackoff.
+func newSocketSink(network, address string) *socketSink {
+	s := &socketSink{network: network, address: address}
+	s.conn, _ = net.DialTimeout(network, address, 5*time.Second)
+	s.lastDialAttempt = time.Now()
+	return s
+}
+
+// DroppedCount returns how many buffered records have been dropped so far because the
+// connection was down and the retry buffer was full.
+func (s *socketSink) DroppedCount() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+func (s *socketSink) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buf.Write(p)
+	if s.buf.Len() > maxSocketBufferBytes {
+		s.dropOverflowRecords()
+	}
+
+	if s.conn == nil {
+		if time.Since(s.lastDialAttempt) < reconnectBackoff {
+			return len(p), nil // still within the backoff window; stays buffered
+		}
+		s.lastDialAttempt = time.Now()
+		var err error
+		if s.conn, err = net.DialTimeout(s.network, s.address, 5*time.Second); err != nil {
+			return len(p), nil // stays buffered; retried on a later Write
+		}
+	}
+
+	if _, err := s.conn.Write(s.buf.Bytes()); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return len(p), nil
+	}
+	s.buf.Reset()
+	return len(p), nil
+}
+
+// dropOverflowRecords trims whole newline-delimited records off the front of s.buf
+// until it's back within maxSocketBufferBytes, incrementing dropped by exactly how
+// many records that took. Trimming at an arbitrary byte offset instead would risk
+// slicing a record in half, corrupting the next bytes sent to the collector.
+func (s *socketSink) dropOverflowRecords() {
+	excess := s.buf.Len() - maxSocketBufferBytes
+	var n int64
+	for excess > 0 {
+		line, err := s.buf.ReadBytes('\n')
+		if len(line) == 0 {
+			break
+		}
+		excess -= len(line)
+		n++
+		if err != nil {
+			// No trailing newline: this was a partial record with nothing left to
+			// split on, so it had to be dropped whole along with the rest.
+			break
+		}
+	}
+	if n > 0 {
+		atomic.AddInt64(&s.dropped, n)
+	}
+}
+
+func (s *socketSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogSeverity maps our Level to the severity component of an RFC 5424 PRI value.
+func syslogSeverity(level Level) int {
+	switch level {
+	case Trace, Debug:
+		return 7 // debug
+	case Info:
+		return 6 // informational
+	case Warn:
+		return 4 // warning
+	case Error:
+		return 3 // err
+	}
+	return 6
+}
+
+// syslogSink ships records to a syslog collector over TCP or UDP, framed as RFC 5424
+// messages. It delegates the underlying connection handling, including reconnect and
+// buffering, to socketSink.
+type syslogSink struct {
+	conn     *socketSink
+	facility int // 0-23, see parseSyslogFacility
+	appName  string
+	hostname string
+	pid      int
+}
+
+// newSyslogSink creates a syslog sink that connects to address over protocol ("tcp" or
+// "udp"). appName identifies this process in each message's APP-NAME field.
+func newSyslogSink(protocol, address string, facility int, appName string) *syslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{
+		conn:     newSocketSink(protocol, address),
+		facility: facility,
+		appName:  appName,
+		hostname: hostname,
+		pid:      os.Getpid(),
+	}
+}
+
+func (s *syslogSink) DroppedCount() int64 {
+	return s.conn.DroppedCount()
+}
+
+// Write implements io.Writer by framing p at the default severity, Info.
+func (s *syslogSink) Write(p []byte) (int, error) {
+	return s.WriteLevel(Info, p)
+}
+
+// WriteLevel frames p as an RFC 5424 message, with a PRI derived from level and the
+// configured facility, and sends it to the underlying socket.
+func (s *syslogSink) WriteLevel(level Level, p []byte) (int, error) {
+	pri := s.facility*8 + syslogSeverity(level)
+	ts := time.Now().Format("2006-01-02T15:04:05.000000Z07:00")
+	msg := strings.TrimRight(string(p), "\n")
+	frame := fmt.Sprintf("<%d>1 %s %s %s %d - - %s\n", pri, ts, s.hostname, s.appName, s.pid, msg)
+	if _, err := s.conn.Write([]byte(frame)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogSink) Close() error {
+	return s.conn.Close()
+}
+
+// parseSyslogFacility maps a facility name, such as "local0" or "daemon", to its
+// RFC 5424 facility code (0-23). An empty name defaults to "user".
+func parseSyslogFacility(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "", "user":
+		return 1, nil
+	case "kern":
+		return 0, nil
+	case "mail":
+		return 2, nil
+	case "daemon":
+		return 3, nil
+	case "auth":
+		return 4, nil
+	case "syslog":
+		return 5, nil
+	case "lpr":
+		return 6, nil
+	case "news":
+		return 7, nil
+	case "uucp":
+		return 8, nil
+	case "authpriv":
+		return 10, nil
+	case "ftp":
+		return 11, nil
+	case "local0":
+		return 16, nil
+	case "local1":
+		return 17, nil
+	case "local2":
+		return 18, nil
+	case "local3":
+		return 19, nil
+	case "local4":
+		return 20, nil
+	case "local5":
+		return 21, nil
+	case "local6":
+		return 22, nil
+	case "local7":
+		return 23, nil
+	}
+	return 0, fmt.Errorf("unknown syslog facility %q", name)
+}
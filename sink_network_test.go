@@ -0,0 +1,185 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslogFacility(t *testing.T) {
+	cases := []struct {
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{"", 1, false},
+		{"user", 1, false},
+		{"local0", 16, false},
+		{"LOCAL7", 23, false},
+		{"daemon", 3, false},
+		{"bogus", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseSyslogFacility(c.name)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseSyslogFacility(%q): expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSyslogFacility(%q): %v", c.name, err)
+		}
+		if got != c.want {
+			t.Errorf("parseSyslogFacility(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	cases := []struct {
+		level Level
+		want  int
+	}{
+		{Trace, 7},
+		{Debug, 7},
+		{Info, 6},
+		{Warn, 4},
+		{Error, 3},
+	}
+	for _, c := range cases {
+		if got := syslogSeverity(c.level); got != c.want {
+			t.Errorf("syslogSeverity(%v) = %v, want %v", c.level, got, c.want)
+		}
+	}
+}
+
+// startTCPCollector listens on an ephemeral local port and returns its address along
+// with a channel of lines it receives.
+func startTCPCollector(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	lines := make(chan string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), lines
+}
+
+func TestSocketSinkDeliversOverTCP(t *testing.T) {
+	addr, lines := startTCPCollector(t)
+	s := newSocketSink("tcp", addr)
+	defer s.Close()
+
+	// The collector may not have called Accept yet; Write retries on the next call
+	// rather than blocking, so keep writing until the collector sees a line or the
+	// deadline passes.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := s.Write([]byte("hello\n")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		select {
+		case line := <-lines:
+			if line != "hello" {
+				t.Fatalf("got %q, want %q", line, "hello")
+			}
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the collector to receive the write")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSocketSinkRespectsReconnectBackoff(t *testing.T) {
+	// lastDialAttempt set to "now" simulates a dial attempt that just happened, so
+	// this Write must not start another one: it should buffer-and-return immediately
+	// rather than blocking on a new DialTimeout.
+	s := &socketSink{network: "tcp", address: "127.0.0.1:1", lastDialAttempt: time.Now()}
+
+	start := time.Now()
+	if _, err := s.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected Write to skip dialing within the backoff window, took %v", elapsed)
+	}
+	if s.conn != nil {
+		t.Fatal("expected no connection to have been established within the backoff window")
+	}
+}
+
+func TestSocketSinkDropsWholeRecordsOnOverflow(t *testing.T) {
+	// lastDialAttempt set to "now" keeps Write from trying (and failing slowly) to
+	// dial; this test is only about the buffer-trimming behaviour.
+	s := &socketSink{network: "tcp", address: "127.0.0.1:1", lastDialAttempt: time.Now()}
+
+	record := append(bytes.Repeat([]byte("a"), 100), '\n')
+	recordsWritten := maxSocketBufferBytes/len(record) + 10
+	for i := 0; i < recordsWritten; i++ {
+		if _, err := s.Write(record); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	if s.buf.Len() > maxSocketBufferBytes {
+		t.Fatalf("buffer exceeded its bound: %v > %v", s.buf.Len(), maxSocketBufferBytes)
+	}
+	if s.buf.Len()%len(record) != 0 {
+		t.Fatalf("buffer holds a partial record: %v bytes is not a multiple of the %v-byte record size", s.buf.Len(), len(record))
+	}
+	recordsRemaining := s.buf.Len() / len(record)
+	if want := int64(recordsWritten - recordsRemaining); s.DroppedCount() != want {
+		t.Fatalf("DroppedCount() = %v, want %v (wrote %v records, %v remain buffered)", s.DroppedCount(), want, recordsWritten, recordsRemaining)
+	}
+}
+
+func TestSyslogSinkFramesRFC5424(t *testing.T) {
+	addr, lines := startTCPCollector(t)
+	s := newSyslogSink("tcp", addr, 16 /* local0 */, "myapp")
+	defer s.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := s.WriteLevel(Error, []byte("boom\n")); err != nil {
+			t.Fatalf("WriteLevel: %v", err)
+		}
+		select {
+		case line := <-lines:
+			// facility 16, severity 3 (err) -> PRI 16*8+3 = 131
+			if !strings.HasPrefix(line, "<131>1 ") {
+				t.Fatalf("unexpected PRI/version in frame: %q", line)
+			}
+			if !strings.Contains(line, "myapp") {
+				t.Fatalf("expected frame to contain app name: %q", line)
+			}
+			if !strings.HasSuffix(line, "boom") {
+				t.Fatalf("expected frame to end with the message: %q", line)
+			}
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the collector to receive the write")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}